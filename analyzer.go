@@ -0,0 +1,277 @@
+package retrieval
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/kljensen/snowball"
+	"github.com/syndtr/goleveldb/leveldb"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Analyzer turns raw document or query text into the sequence of terms that
+// get indexed or searched on. InvertedIndex and VectorIndex both use the
+// same Analyzer for Add and Search, so e.g. Search("Running") can match a
+// document containing "runs".
+type Analyzer interface {
+	// Name identifies this Analyzer's configuration (e.g. which TokenFilters
+	// it chains and in what order). It's persisted in the index the first
+	// time it's opened; reopening with an Analyzer whose Name differs is
+	// refused, since that would silently shift the term space out from
+	// under postings already on disk.
+	Name() string
+	Analyze(text string) []string
+}
+
+// TokenFilter transforms one token stream into another, e.g. lowercasing,
+// stopword removal, or stemming. Analyzers are built by chaining
+// TokenFilters after an initial tokenization pass.
+type TokenFilter interface {
+	Filter(tokens []string) []string
+}
+
+// StandardAnalyzer tokenizes text into runs of letters and digits (see
+// unicode.IsLetter / unicode.IsDigit), then applies a chain of TokenFilters
+// in order.
+type StandardAnalyzer struct {
+	name    string
+	filters []TokenFilter
+}
+
+var _ Analyzer = (*StandardAnalyzer)(nil)
+
+// NewStandardAnalyzer builds an Analyzer from a chain of TokenFilters,
+// applied in the order given. name must uniquely identify this
+// configuration; see Analyzer.Name.
+func NewStandardAnalyzer(name string, filters ...TokenFilter) *StandardAnalyzer {
+	return &StandardAnalyzer{name: name, filters: filters}
+}
+
+func (a *StandardAnalyzer) Name() string {
+	return a.name
+}
+
+func (a *StandardAnalyzer) Analyze(text string) []string {
+	tokens := tokenizeUnicode(text)
+	for _, filter := range a.filters {
+		tokens = filter.Filter(tokens)
+	}
+	return tokens
+}
+
+// tokenizeUnicode splits text into maximal runs of letters and digits,
+// discarding everything else (whitespace and punctuation alike).
+func tokenizeUnicode(text string) []string {
+	var tokens []string
+	var current []rune
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			current = append(current, r)
+			continue
+		}
+		if len(current) > 0 {
+			tokens = append(tokens, string(current))
+			current = nil
+		}
+	}
+	if len(current) > 0 {
+		tokens = append(tokens, string(current))
+	}
+	return tokens
+}
+
+// defaultAnalyzer reproduces the original whitespace-only tokenization, so
+// an Index opened without an explicit Analyzer keeps behaving exactly as it
+// did before Analyzers existed.
+type defaultAnalyzer struct{}
+
+func (defaultAnalyzer) Name() string {
+	return "default"
+}
+
+func (defaultAnalyzer) Analyze(text string) []string {
+	return tokenize(text)
+}
+
+// metaAnalyzerKey is an exact (non-prefix) key reserved for index metadata.
+const metaAnalyzerKey = "meta"
+
+// checkAnalyzer persists analyzer's Name the first time an index is opened,
+// and on every later open verifies the stored name still matches. This
+// guards against silently reopening an index with a different Analyzer,
+// which would shift the term space under the df/tf/ft counters already on
+// disk.
+func checkAnalyzer(db *leveldb.DB, analyzer Analyzer) error {
+	key := []byte(metaAnalyzerKey)
+	has, err := db.Has(key, nil)
+	if err != nil {
+		return err
+	}
+	name := analyzer.Name()
+	if !has {
+		return db.Put(key, []byte(name), nil)
+	}
+
+	storedNameBytes, err := db.Get(key, nil)
+	if err != nil {
+		return err
+	}
+	if string(storedNameBytes) != name {
+		errorMessage := fmt.Sprintf(
+			"Index was created with analyzer %q; refusing to open it with analyzer %q",
+			storedNameBytes,
+			name)
+		return errors.New(errorMessage)
+	}
+	return nil
+}
+
+// LowercaseFilter lowercases every token.
+type LowercaseFilter struct{}
+
+var _ TokenFilter = LowercaseFilter{}
+
+func (LowercaseFilter) Filter(tokens []string) []string {
+	result := make([]string, len(tokens))
+	for i, token := range tokens {
+		result[i] = strings.ToLower(token)
+	}
+	return result
+}
+
+// asciiFoldTransformer strips combining marks left behind by decomposing
+// accented characters, e.g. "café" -> "cafe".
+var asciiFoldTransformer = transform.Chain(
+	norm.NFD,
+	runes.Remove(runes.In(unicode.Mn)),
+	norm.NFC)
+
+// ASCIIFoldFilter folds accented Latin characters to their unaccented ASCII
+// equivalent, so that e.g. "café" and "cafe" index to the same term.
+type ASCIIFoldFilter struct{}
+
+var _ TokenFilter = ASCIIFoldFilter{}
+
+func (ASCIIFoldFilter) Filter(tokens []string) []string {
+	result := make([]string, len(tokens))
+	for i, token := range tokens {
+		folded, _, err := transform.String(asciiFoldTransformer, token)
+		if err != nil {
+			folded = token
+		}
+		result[i] = folded
+	}
+	return result
+}
+
+// StopwordFilter drops any token in its configured stopword set.
+type StopwordFilter struct {
+	stopwords map[string]struct{}
+}
+
+var _ TokenFilter = StopwordFilter{}
+
+func NewStopwordFilter(stopwords []string) StopwordFilter {
+	set := make(map[string]struct{}, len(stopwords))
+	for _, word := range stopwords {
+		set[word] = struct{}{}
+	}
+	return StopwordFilter{stopwords: set}
+}
+
+func (f StopwordFilter) Filter(tokens []string) []string {
+	result := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		if _, ok := f.stopwords[token]; ok {
+			continue
+		}
+		result = append(result, token)
+	}
+	return result
+}
+
+// EnglishStopwords is a set of common English stopwords, suitable for
+// passing to NewStopwordFilter. Expected to be applied after a
+// LowercaseFilter, since the list is all lowercase.
+var EnglishStopwords = []string{
+	"a", "an", "and", "are", "as", "at", "be", "by", "for", "from",
+	"has", "he", "in", "is", "it", "its", "of", "on", "that", "the",
+	"to", "was", "were", "will", "with",
+}
+
+// EnglishStemFilter reduces each token to its Porter2/Snowball stem (e.g.
+// "running" -> "run"), so that different inflections of a word index and
+// search as the same term.
+type EnglishStemFilter struct{}
+
+var _ TokenFilter = EnglishStemFilter{}
+
+func (EnglishStemFilter) Filter(tokens []string) []string {
+	result := make([]string, len(tokens))
+	for i, token := range tokens {
+		stemmed, err := snowball.Stem(token, "english", false)
+		if err != nil {
+			stemmed = token
+		}
+		result[i] = stemmed
+	}
+	return result
+}
+
+// NGramFilter replaces each token with all of its contiguous substrings of
+// length between MinGram and MaxGram (inclusive). Combined with the same
+// filter at query time, this lets short substrings match inside longer
+// indexed terms.
+type NGramFilter struct {
+	MinGram int
+	MaxGram int
+}
+
+var _ TokenFilter = NGramFilter{}
+
+func (f NGramFilter) Filter(tokens []string) []string {
+	var result []string
+	for _, token := range tokens {
+		chars := []rune(token)
+		maxGram := f.MaxGram
+		if maxGram > len(chars) {
+			maxGram = len(chars)
+		}
+		for n := f.MinGram; n <= maxGram; n++ {
+			for i := 0; i+n <= len(chars); i++ {
+				result = append(result, string(chars[i:i+n]))
+			}
+		}
+	}
+	return result
+}
+
+// EdgeNGramFilter replaces each token with its prefixes of length between
+// MinGram and MaxGram (inclusive), e.g. "search" with MinGram=2, MaxGram=4
+// becomes ["se", "sea", "sear"]. This is the usual shape for prefix /
+// autocomplete search, as opposed to NGramFilter's arbitrary substrings.
+type EdgeNGramFilter struct {
+	MinGram int
+	MaxGram int
+}
+
+var _ TokenFilter = EdgeNGramFilter{}
+
+func (f EdgeNGramFilter) Filter(tokens []string) []string {
+	var result []string
+	for _, token := range tokens {
+		chars := []rune(token)
+		maxGram := f.MaxGram
+		if maxGram > len(chars) {
+			maxGram = len(chars)
+		}
+		for n := f.MinGram; n <= maxGram; n++ {
+			result = append(result, string(chars[:n]))
+		}
+	}
+	return result
+}
@@ -0,0 +1,81 @@
+package retrieval
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStandardAnalyzer(t *testing.T) {
+	analyzer := NewStandardAnalyzer(
+		"lowercase-stopwords-stem",
+		LowercaseFilter{},
+		NewStopwordFilter(EnglishStopwords),
+		EnglishStemFilter{})
+
+	for _, testCase := range []struct {
+		input    string
+		expected []string
+	}{
+		{"Running, runs, and ran!", []string{"run", "run", "ran"}},
+		{"The quick fox", []string{"quick", "fox"}},
+	} {
+		output := analyzer.Analyze(testCase.input)
+		if !reflect.DeepEqual(testCase.expected, output) {
+			t.Errorf(
+				"Expected %q for input %q; got %q",
+				testCase.expected,
+				testCase.input,
+				output)
+		}
+	}
+}
+
+func TestASCIIFoldFilter(t *testing.T) {
+	output := ASCIIFoldFilter{}.Filter([]string{"café", "naïve"})
+	expected := []string{"cafe", "naive"}
+	if !reflect.DeepEqual(expected, output) {
+		t.Errorf("Expected %q; got %q", expected, output)
+	}
+}
+
+func TestEdgeNGramFilter(t *testing.T) {
+	output := EdgeNGramFilter{MinGram: 2, MaxGram: 4}.Filter([]string{"search"})
+	expected := []string{"se", "sea", "sear"}
+	if !reflect.DeepEqual(expected, output) {
+		t.Errorf("Expected %q; got %q", expected, output)
+	}
+}
+
+func TestCheckAnalyzerRejectsMismatch(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() {
+		err := os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	location := filepath.Join(tempDir, "v")
+	v, err := OpenVectorIndexWithOptions(
+		location,
+		VectorIndexOptions{Analyzer: NewStandardAnalyzer("a", LowercaseFilter{})})
+	if err != nil {
+		t.Error(err)
+	}
+	if err := v.Close(); err != nil {
+		t.Error(err)
+	}
+
+	_, err = OpenVectorIndexWithOptions(
+		location,
+		VectorIndexOptions{Analyzer: NewStandardAnalyzer("b", LowercaseFilter{})})
+	if err == nil {
+		t.Error("Expected an error opening an index with a different analyzer")
+	}
+}
@@ -0,0 +1,238 @@
+package retrieval
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestVectorIndexAddDeleteAddMatchesSingleAdd(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() {
+		err := os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	baseline := filepath.Join(tempDir, "baseline")
+	v1, err := OpenVectorIndex(baseline)
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() {
+		err := v1.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+	err = v1.Add("other.txt", "rumic tiger")
+	if err != nil {
+		t.Error(err)
+	}
+	err = v1.Add("file.txt", "foo foo bar baz")
+	if err != nil {
+		t.Error(err)
+	}
+
+	churned := filepath.Join(tempDir, "churned")
+	v2, err := OpenVectorIndex(churned)
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() {
+		err := v2.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+	err = v2.Add("other.txt", "rumic tiger")
+	if err != nil {
+		t.Error(err)
+	}
+	err = v2.Add("file.txt", "foo foo bar baz")
+	if err != nil {
+		t.Error(err)
+	}
+	err = v2.Delete("file.txt")
+	if err != nil {
+		t.Error(err)
+	}
+	err = v2.Add("file.txt", "foo foo bar baz")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !reflect.DeepEqual(v1.dfCache, v2.dfCache) {
+		t.Errorf("Expected dfCache %v; got %v", v1.dfCache, v2.dfCache)
+	}
+	if v1.docCount != v2.docCount {
+		t.Errorf("Expected docCount %d; got %d", v1.docCount, v2.docCount)
+	}
+	if v1.averageDocumentLength() != v2.averageDocumentLength() {
+		t.Errorf(
+			"Expected averageDocumentLength %f; got %f",
+			v1.averageDocumentLength(),
+			v2.averageDocumentLength())
+	}
+}
+
+func TestVectorIndexDeleteRemovesDocument(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() {
+		err := os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	location := filepath.Join(tempDir, "v")
+	v, err := OpenVectorIndex(location)
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() {
+		err := v.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	err = v.Add("a", "hello world")
+	if err != nil {
+		t.Error(err)
+	}
+	err = v.Add("b", "hello there")
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = v.Delete("a")
+	if err != nil {
+		t.Error(err)
+	}
+
+	termFreqs, err := v.documentTermFreqs("a")
+	if err != nil {
+		t.Error(err)
+	}
+	if len(termFreqs) != 0 {
+		t.Errorf("Expected no term frequencies for deleted file; got %v", termFreqs)
+	}
+
+	// "world" was only ever in the deleted document "a", so its df drops to
+	// 0 rather than disappearing from dfCache (matching how dfCache is
+	// rebuilt from the df<term> keys actually stored in the db).
+	expectedDF := map[string]uint32{
+		"hello": 1,
+		"there": 1,
+		"world": 0,
+	}
+	if !reflect.DeepEqual(expectedDF, v.dfCache) {
+		t.Errorf("Expected dfCache %v; got %v", expectedDF, v.dfCache)
+	}
+	if v.docCount != 1 {
+		t.Errorf("Expected docCount 1; got %d", v.docCount)
+	}
+
+	result, err := v.Search("world")
+	if err != nil {
+		t.Error(err)
+	}
+	if len(result) != 0 {
+		t.Errorf("Expected no results for deleted document's term; got %q", result)
+	}
+
+	// Deleting a file that was never added (or already deleted) is a no-op.
+	err = v.Delete("a")
+	if err != nil {
+		t.Error(err)
+	}
+	if v.docCount != 1 {
+		t.Errorf("Expected docCount to stay 1 after no-op Delete; got %d", v.docCount)
+	}
+}
+
+// TestVectorIndexZeroTermDocumentIsIdempotent guards against collectRemoval
+// mistaking "analyzes to zero terms" (e.g. an all-stopword document) for
+// "was never added": such a document has no ft postings to key off of, but
+// still needs to be recognized as already-indexed so re-Add doesn't
+// double-charge docCount and Delete isn't a permanent no-op.
+func TestVectorIndexZeroTermDocumentIsIdempotent(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() {
+		err := os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	location := filepath.Join(tempDir, "v")
+	analyzer := NewStandardAnalyzer(
+		"lowercase+stopwords",
+		LowercaseFilter{},
+		NewStopwordFilter(EnglishStopwords))
+	v, err := OpenVectorIndexWithOptions(
+		location, VectorIndexOptions{Analyzer: analyzer})
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() {
+		err := v.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	err = v.Add("real.txt", "hello world")
+	if err != nil {
+		t.Error(err)
+	}
+	err = v.Add("x", "the and is")
+	if err != nil {
+		t.Error(err)
+	}
+	if v.docCount != 2 {
+		t.Errorf("Expected docCount 2 after adding a zero-term document; got %d", v.docCount)
+	}
+
+	// Re-Adding the same zero-term document must not charge docCount again.
+	err = v.Add("x", "the and is")
+	if err != nil {
+		t.Error(err)
+	}
+	if v.docCount != 2 {
+		t.Errorf(
+			"Expected docCount to stay 2 after re-Add of a zero-term document; got %d",
+			v.docCount)
+	}
+
+	err = v.Delete("x")
+	if err != nil {
+		t.Error(err)
+	}
+	if v.docCount != 1 {
+		t.Errorf(
+			"Expected docCount 1 after deleting a zero-term document; got %d",
+			v.docCount)
+	}
+
+	length, err := v.documentLength("x")
+	if err != nil {
+		t.Error(err)
+	}
+	if length != 0 {
+		t.Errorf("Expected deleted document's dl entry to be gone; got %d", length)
+	}
+}
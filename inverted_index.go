@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"sort"
 
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/util"
@@ -13,11 +14,20 @@ import (
 var nullByte = byte(0)
 
 type InvertedIndex struct {
-	db *leveldb.DB
+	db       *leveldb.DB
+	analyzer Analyzer
 }
 
 var _ Index = (*InvertedIndex)(nil)
 
+// InvertedIndexOptions controls optional behavior of an InvertedIndex.
+type InvertedIndexOptions struct {
+	// Analyzer controls how Add and Search turn text into terms. Defaults
+	// to whitespace-only tokenization, matching the index's original
+	// behavior, if not given.
+	Analyzer Analyzer
+}
+
 func makeKey(token string, filename string) []byte {
 	result := make([]byte, 0, len(token)+1+len(filename))
 	result = append(result, []byte(token)...)
@@ -27,53 +37,84 @@ func makeKey(token string, filename string) []byte {
 }
 
 func OpenInvertedIndex(location string) (*InvertedIndex, error) {
+	return OpenInvertedIndexWithOptions(location, InvertedIndexOptions{})
+}
+
+func OpenInvertedIndexWithOptions(
+	location string,
+	options InvertedIndexOptions) (*InvertedIndex, error) {
+
 	db, err := leveldb.OpenFile(location, nil)
 	if err != nil {
 		return nil, err
 	}
+
+	analyzer := options.Analyzer
+	if analyzer == nil {
+		analyzer = defaultAnalyzer{}
+	}
+	if err := checkAnalyzer(db, analyzer); err != nil {
+		return nil, err
+	}
+
 	return &InvertedIndex{
-		db: db,
+		db:       db,
+		analyzer: analyzer,
 	}, nil
 }
 
 func (ii *InvertedIndex) Add(filename string, contents string) error {
-	tokens := tokenize(contents)
+	tokens := ii.analyzer.Analyze(contents)
+	batch := new(leveldb.Batch)
 	for _, token := range tokens {
 		key := makeKey(token, filename)
-		err := ii.db.Put(key, nil, nil)
-		if err != nil {
-			return err
-		}
+		batch.Put(key, nil)
 	}
-	return nil
+	return ii.db.Write(batch, nil)
 }
 
+// Search returns every document containing at least one term of query (after
+// analysis), i.e. an OR across all analyzed terms, sorted by filename. It
+// does not rank or weight documents by how many terms they match; use
+// VectorIndex for that.
 func (ii *InvertedIndex) Search(query string) ([]string, error) {
-	prefix := []byte(query)
-	prefix = append(prefix, nullByte)
-	prefixRange := util.BytesPrefix(prefix)
+	terms := ii.analyzer.Analyze(query)
+	if len(terms) == 0 {
+		return []string{}, nil
+	}
 
-	iter := ii.db.NewIterator(prefixRange, nil)
-	defer func() {
+	candidates := make(map[string]struct{})
+	for term := range uniqueTerms(terms) {
+		prefix := []byte(term)
+		prefix = append(prefix, nullByte)
+		prefixRange := util.BytesPrefix(prefix)
+
+		iter := ii.db.NewIterator(prefixRange, nil)
+		for iter.Next() {
+			key := iter.Key()
+			sep := bytes.IndexByte(key, nullByte)
+			if sep < 0 {
+				iter.Release()
+				errorMessage := fmt.Sprintf(
+					"Invalid key format: %q; possible index corruption?",
+					key)
+				return nil, errors.New(errorMessage)
+			}
+			filename := string(key[sep+1:])
+			candidates[filename] = struct{}{}
+		}
+		err := iter.Error()
 		iter.Release()
-		if err := iter.Error(); err != nil {
+		if err != nil {
 			log.Print(err)
 		}
-	}()
-
-	result := make([]string, 0)
-	for iter.Next() {
-		key := iter.Key()
-		sep := bytes.IndexByte(key, nullByte)
-		if sep < 0 {
-			errorMessage := fmt.Sprintf(
-				"Invalid key format: %q; possible index corruption?",
-				key)
-			return nil, errors.New(errorMessage)
-		}
-		filename := string(key[sep+1:])
+	}
+
+	result := make([]string, 0, len(candidates))
+	for filename := range candidates {
 		result = append(result, filename)
 	}
+	sort.Strings(result)
 	return result, nil
 }
 
@@ -53,6 +53,9 @@ func TestInvertedIndex(t *testing.T) {
 		{"hello", []string{"a", "b"}},
 		{"tiger", []string{"b"}},
 		{"world", []string{"a", "c"}},
+		// A multi-term query ORs across every analyzed term rather than
+		// matching only the first.
+		{"tiger rumic", []string{"b", "c"}},
 	} {
 		result, err := ii.Search(testCase.query)
 		if err != nil {
@@ -0,0 +1,190 @@
+package retrieval
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// encodePositions varint-delta-encodes a sorted list of token offsets: the
+// first offset is stored as-is, and every later one as the gap from its
+// predecessor. Deltas are non-negative as long as positions is sorted
+// ascending, which is how VectorIndex.Add builds it.
+func encodePositions(positions []uint32) []byte {
+	buf := make([]byte, 0, len(positions)*2)
+	varint := make([]byte, binary.MaxVarintLen32)
+	var prev uint32
+	for i, position := range positions {
+		delta := position
+		if i > 0 {
+			delta = position - prev
+		}
+		n := binary.PutUvarint(varint, uint64(delta))
+		buf = append(buf, varint[:n]...)
+		prev = position
+	}
+	return buf
+}
+
+// decodePositions is the inverse of encodePositions.
+func decodePositions(data []byte) ([]uint32, error) {
+	var positions []uint32
+	var prev uint32
+	for len(data) > 0 {
+		delta, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errors.New("invalid varint in position posting")
+		}
+		data = data[n:]
+		position := uint32(delta)
+		if len(positions) > 0 {
+			position = prev + uint32(delta)
+		}
+		positions = append(positions, position)
+		prev = position
+	}
+	return positions, nil
+}
+
+// documentTermPositions returns the token offsets at which term occurs in
+// filename, or nil if it doesn't occur there at all.
+func (v *VectorIndex) documentTermPositions(
+	term string,
+	filename string) ([]uint32, error) {
+
+	key := []byte(posPrefix)
+	key = append(key, joinWithNullSep(term, filename)...)
+	has, err := v.db.Has(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, nil
+	}
+	valueBytes, err := v.db.Get(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodePositions(valueBytes)
+}
+
+// termPositions maps every filename containing term to term's positions in
+// that document, using the existing tf<term>\0<filename> range to enumerate
+// candidates.
+func (v *VectorIndex) termPositions(term string) (map[string][]uint32, error) {
+	prefix := []byte(tfPrefix)
+	prefix = append(prefix, []byte(term)...)
+	prefix = append(prefix, nullByte)
+
+	result := make(map[string][]uint32)
+	iterFunc := func(key []byte, value []byte) error {
+		sep := bytes.IndexByte(key, nullByte)
+		if sep < 0 {
+			errorMessage := fmt.Sprintf(
+				"Invalid key format: %q; possible index corruption?",
+				key)
+			return errors.New(errorMessage)
+		}
+		filename := string(key[sep+1:])
+		positions, err := v.documentTermPositions(term, filename)
+		if err != nil {
+			return err
+		}
+		result[filename] = positions
+		return nil
+	}
+	err := iterate(v.db, prefix, iterFunc)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// phraseSearch returns every document in which terms occur, in order,
+// within slop tokens of each other (slop=0 requires an exact, contiguous
+// phrase), mapped to the smallest gap achieved. The gap is
+// p_n - p_1 - (len(terms)-1), i.e. how many extra tokens separate the first
+// and last term beyond a perfectly contiguous match.
+func (v *VectorIndex) phraseSearch(
+	terms []string,
+	slop int) (map[string]int, error) {
+
+	if len(terms) == 0 {
+		return map[string]int{}, nil
+	}
+
+	postingsByTerm := make([]map[string][]uint32, len(terms))
+	for i, term := range terms {
+		postings, err := v.termPositions(term)
+		if err != nil {
+			return nil, err
+		}
+		postingsByTerm[i] = postings
+	}
+
+	result := make(map[string]int)
+	for filename, firstTermPositions := range postingsByTerm[0] {
+		docPositions := make([][]uint32, len(terms))
+		docPositions[0] = firstTermPositions
+		complete := true
+		for i := 1; i < len(terms); i++ {
+			positions, ok := postingsByTerm[i][filename]
+			if !ok {
+				complete = false
+				break
+			}
+			docPositions[i] = positions
+		}
+		if !complete {
+			continue
+		}
+		if gap, ok := minPhraseGap(docPositions); ok && gap <= slop {
+			result[filename] = gap
+		}
+	}
+	return result, nil
+}
+
+// minPhraseGap finds, among every way of picking one strictly-increasing
+// position from each list in positions (one list per query term, in query
+// order), the one that minimizes p_last - p_first - (len(positions)-1) --
+// i.e. the tightest in-order match. It returns false if no such increasing
+// sequence exists at all.
+func minPhraseGap(positions [][]uint32) (int, bool) {
+	best := -1
+	for _, start := range positions[0] {
+		prev := start
+		ok := true
+		for i := 1; i < len(positions); i++ {
+			next, found := firstGreaterThan(positions[i], prev)
+			if !found {
+				ok = false
+				break
+			}
+			prev = next
+		}
+		if !ok {
+			continue
+		}
+		gap := int(prev) - int(start) - (len(positions) - 1)
+		if best == -1 || gap < best {
+			best = gap
+		}
+	}
+	if best == -1 {
+		return 0, false
+	}
+	return best, true
+}
+
+// firstGreaterThan returns the smallest element of sorted that is strictly
+// greater than x, assuming sorted is in ascending order.
+func firstGreaterThan(sorted []uint32, x uint32) (uint32, bool) {
+	i := sort.Search(len(sorted), func(i int) bool { return sorted[i] > x })
+	if i == len(sorted) {
+		return 0, false
+	}
+	return sorted[i], true
+}
@@ -0,0 +1,129 @@
+package retrieval
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeDecodePositions(t *testing.T) {
+	positions := []uint32{3, 5, 6, 100}
+	encoded := encodePositions(positions)
+	decoded, err := decodePositions(encoded)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(positions, decoded) {
+		t.Errorf("Expected %v; got %v", positions, decoded)
+	}
+}
+
+func TestVectorIndexPhraseSearch(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() {
+		err := os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	location := filepath.Join(tempDir, "v")
+	v, err := OpenVectorIndex(location)
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() {
+		err := v.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	for _, input := range []struct {
+		filename string
+		contents string
+	}{
+		{"a", "new york is a city"},
+		{"b", "new orleans is a city"},
+		{"c", "a new and improved york"},
+	} {
+		err := v.Add(input.filename, input.contents)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	for _, testCase := range []struct {
+		terms    []string
+		slop     int
+		expected map[string]int
+	}{
+		{[]string{"new", "york"}, 0, map[string]int{"a": 0}},
+		{[]string{"new", "york"}, 2, map[string]int{"a": 0, "c": 2}},
+	} {
+		result, err := v.phraseSearch(testCase.terms, testCase.slop)
+		if err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(testCase.expected, result) {
+			t.Errorf(
+				"Expected %v for terms %q slop %d; got %v",
+				testCase.expected,
+				testCase.terms,
+				testCase.slop,
+				result)
+		}
+	}
+}
+
+func TestVectorIndexSearchPhraseBoost(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() {
+		err := os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	location := filepath.Join(tempDir, "v")
+	v, err := OpenVectorIndex(location)
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() {
+		err := v.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	for _, input := range []struct {
+		filename string
+		contents string
+	}{
+		{"a", "new york is a city"},
+		{"b", "new orleans is a city, and york is a surname"},
+	} {
+		err := v.Add(input.filename, input.contents)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	result, err := v.Search(`"new york"`)
+	if err != nil {
+		t.Error(err)
+	}
+	expected := []string{"a", "b"}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected %q; got %q", expected, result)
+	}
+}
@@ -0,0 +1,81 @@
+package retrieval
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// PhraseClause is a parsed phrase ("new york") or NEAR/k (foo NEAR/3 bar)
+// clause: its Terms must occur, in order, within Slop tokens of each other
+// for a document to satisfy it. A quoted phrase is a NEAR/0 clause.
+type PhraseClause struct {
+	Terms []string
+	Slop  int
+}
+
+// ParsedQuery splits a raw query into its bare terms and any phrase/NEAR
+// clauses, so VectorIndex.SearchWithRanker can send the former through
+// normal term-at-a-time scoring and the latter through phraseSearch.
+type ParsedQuery struct {
+	Terms   []string
+	Phrases []PhraseClause
+}
+
+// AllTerms returns every term mentioned anywhere in the query: bare terms
+// plus every phrase clause's terms. Candidate document lookup and
+// non-positional scoring (TF-IDF, BM25) don't distinguish phrase terms from
+// bare terms; only phraseSearch does.
+func (q *ParsedQuery) AllTerms() []string {
+	terms := make([]string, len(q.Terms))
+	copy(terms, q.Terms)
+	for _, phrase := range q.Phrases {
+		terms = append(terms, phrase.Terms...)
+	}
+	return terms
+}
+
+var quotedPhraseRegexp = regexp.MustCompile(`"([^"]*)"`)
+var nearRegexp = regexp.MustCompile(`(\S+)\s+NEAR/(\d+)\s+(\S+)`)
+
+// ParseQuery recognizes double-quoted phrases and a NEAR/k operator in
+// query, analyzing each clause's text with analyzer so the query is
+// tokenized the same way as indexed documents. Whatever text is left over
+// after removing phrase and NEAR/k clauses is analyzed into Terms.
+func ParseQuery(query string, analyzer Analyzer) *ParsedQuery {
+	parsed := &ParsedQuery{}
+
+	remaining := quotedPhraseRegexp.ReplaceAllStringFunc(
+		query,
+		func(match string) string {
+			submatches := quotedPhraseRegexp.FindStringSubmatch(match)
+			terms := analyzer.Analyze(submatches[1])
+			if len(terms) > 0 {
+				parsed.Phrases = append(
+					parsed.Phrases,
+					PhraseClause{Terms: terms, Slop: 0})
+			}
+			return " "
+		})
+
+	remaining = nearRegexp.ReplaceAllStringFunc(
+		remaining,
+		func(match string) string {
+			submatches := nearRegexp.FindStringSubmatch(match)
+			slop, err := strconv.Atoi(submatches[2])
+			if err != nil {
+				return match
+			}
+			terms := append(
+				analyzer.Analyze(submatches[1]),
+				analyzer.Analyze(submatches[3])...)
+			if len(terms) > 0 {
+				parsed.Phrases = append(
+					parsed.Phrases,
+					PhraseClause{Terms: terms, Slop: slop})
+			}
+			return " "
+		})
+
+	parsed.Terms = analyzer.Analyze(remaining)
+	return parsed
+}
@@ -0,0 +1,42 @@
+package retrieval
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	analyzer := NewStandardAnalyzer("test")
+
+	for _, testCase := range []struct {
+		query    string
+		expected *ParsedQuery
+	}{
+		{
+			"hello world",
+			&ParsedQuery{Terms: []string{"hello", "world"}},
+		},
+		{
+			`"new york" city`,
+			&ParsedQuery{
+				Terms:   []string{"city"},
+				Phrases: []PhraseClause{{Terms: []string{"new", "york"}, Slop: 0}},
+			},
+		},
+		{
+			"foo NEAR/3 bar",
+			&ParsedQuery{
+				Phrases: []PhraseClause{{Terms: []string{"foo", "bar"}, Slop: 3}},
+			},
+		},
+	} {
+		result := ParseQuery(testCase.query, analyzer)
+		if !reflect.DeepEqual(testCase.expected, result) {
+			t.Errorf(
+				"Expected %+v for query %q; got %+v",
+				testCase.expected,
+				testCase.query,
+				result)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package retrieval
+
+import "math"
+
+// Ranker scores a single candidate document against a tokenized query.
+// VectorIndex.Search (via SearchWithRanker) uses a Ranker to order
+// candidates; higher scores sort first.
+type Ranker interface {
+	Score(query []string, doc string) (float64, error)
+}
+
+// TFIDFRanker scores documents by the cosine similarity between their TF-IDF
+// vector and the query's TF-IDF vector, where the weight of a term t in a
+// vector is tf(t) * log(N/df(t)).
+type TFIDFRanker struct {
+	v *VectorIndex
+}
+
+var _ Ranker = (*TFIDFRanker)(nil)
+
+func NewTFIDFRanker(v *VectorIndex) *TFIDFRanker {
+	return &TFIDFRanker{v: v}
+}
+
+func (r *TFIDFRanker) Score(query []string, doc string) (float64, error) {
+	docTermFreqs, err := r.v.documentTermFreqs(doc)
+	if err != nil {
+		return 0, err
+	}
+	queryTermFreqs := make(map[string]uint32, len(query))
+	for _, term := range query {
+		queryTermFreqs[term]++
+	}
+
+	d := r.tfIDFVector(docTermFreqs)
+	q := r.tfIDFVector(queryTermFreqs)
+
+	denominator := l2Norm(d) * l2Norm(q)
+	if denominator == 0 {
+		return 0, nil
+	}
+	return dotProduct(d, q) / denominator, nil
+}
+
+// tfIDFVector weights each term's raw frequency by log(N/df(t)), dropping
+// terms that have never been indexed (df(t) == 0).
+func (r *TFIDFRanker) tfIDFVector(
+	termFreqs map[string]uint32) map[string]float64 {
+
+	n := float64(r.v.docCount)
+	vector := make(map[string]float64, len(termFreqs))
+	for term, tf := range termFreqs {
+		df := r.v.dfCache[term]
+		if df == 0 {
+			continue
+		}
+		vector[term] = float64(tf) * math.Log(n/float64(df))
+	}
+	return vector
+}
+
+func dotProduct(x map[string]float64, y map[string]float64) float64 {
+	result := float64(0)
+	for term, weight := range x {
+		result += weight * y[term]
+	}
+	return result
+}
+
+func l2Norm(vector map[string]float64) float64 {
+	sumSquares := float64(0)
+	for _, weight := range vector {
+		sumSquares += weight * weight
+	}
+	return math.Sqrt(sumSquares)
+}
+
+// DefaultBM25K1 and DefaultBM25B are the term-frequency saturation and
+// length-normalization constants recommended in the original Okapi BM25
+// literature, and are what NewBM25Ranker uses.
+const (
+	DefaultBM25K1 = 1.2
+	DefaultBM25B  = 0.75
+)
+
+// BM25Ranker scores documents using Okapi BM25.
+type BM25Ranker struct {
+	v  *VectorIndex
+	k1 float64
+	b  float64
+
+	// phraseGaps, if set, adds a 1/(1+gap) boost to any document present
+	// here, reflecting how tightly it matched a phrase or NEAR/k clause of
+	// the query. See WithPhraseBoosts.
+	phraseGaps map[string]int
+}
+
+var _ Ranker = (*BM25Ranker)(nil)
+
+func NewBM25Ranker(v *VectorIndex) *BM25Ranker {
+	return &BM25Ranker{v: v, k1: DefaultBM25K1, b: DefaultBM25B}
+}
+
+// WithPhraseBoosts returns a copy of r that adds a 1/(1+gap) boost to
+// Score's result for any document in gaps, where gap is the smallest
+// distance by which that document satisfied a phrase/NEAR clause (0 for an
+// exact, contiguous match). This is how VectorIndex.SearchWithRanker
+// reflects ParseQuery's phrase clauses in BM25 ranking, without requiring
+// every Ranker to understand phrase clauses.
+func (r *BM25Ranker) WithPhraseBoosts(gaps map[string]int) *BM25Ranker {
+	boosted := *r
+	boosted.phraseGaps = gaps
+	return &boosted
+}
+
+func (r *BM25Ranker) Score(query []string, doc string) (float64, error) {
+	docTermFreqs, err := r.v.documentTermFreqs(doc)
+	if err != nil {
+		return 0, err
+	}
+	docLength, err := r.v.documentLength(doc)
+	if err != nil {
+		return 0, err
+	}
+	avgdl := r.v.averageDocumentLength()
+	n := float64(r.v.docCount)
+
+	score := float64(0)
+	seen := make(map[string]struct{}, len(query))
+	for _, term := range query {
+		if _, ok := seen[term]; ok {
+			continue
+		}
+		seen[term] = struct{}{}
+
+		f := float64(docTermFreqs[term])
+		if f == 0 {
+			continue
+		}
+		df := float64(r.v.dfCache[term])
+		idf := math.Log((n-df+0.5)/(df+0.5) + 1)
+		numerator := f * (r.k1 + 1)
+		denominator := f + r.k1*(1-r.b+r.b*float64(docLength)/avgdl)
+		score += idf * numerator / denominator
+	}
+
+	if gap, ok := r.phraseGaps[doc]; ok {
+		score += 1 / float64(1+gap)
+	}
+	return score, nil
+}
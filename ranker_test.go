@@ -0,0 +1,175 @@
+package retrieval
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestVectorIndexSearchWithRanker(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() {
+		err := os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	location := filepath.Join(tempDir, "v")
+	v, err := OpenVectorIndex(location)
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() {
+		err := v.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	for _, input := range []struct {
+		filename string
+		contents string
+	}{
+		{"a", "hello hello hello hello world"},
+		{"b", "hello tiger tiger"},
+		{"c", "rumic tiger"},
+	} {
+		err := v.Add(input.filename, input.contents)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	for _, testCase := range []struct {
+		ranker   Ranker
+		query    string
+		expected []string
+	}{
+		{NewBM25Ranker(v), "hello", []string{"a", "b"}},
+		{NewTFIDFRanker(v), "hello", []string{"a", "b"}},
+		{NewTFIDFRanker(v), "tiger", []string{"b", "c"}},
+	} {
+		result, err := v.SearchWithRanker(testCase.query, testCase.ranker)
+		if err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(testCase.expected, result) {
+			t.Errorf(
+				"Expected %q for query %q; got %q",
+				testCase.expected,
+				testCase.query,
+				result)
+		}
+	}
+}
+
+func TestBM25RankerScore(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() {
+		err := os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	location := filepath.Join(tempDir, "v")
+	v, err := OpenVectorIndex(location)
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() {
+		err := v.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	err = v.Add("a", "foo bar")
+	if err != nil {
+		t.Error(err)
+	}
+
+	ranker := NewBM25Ranker(v)
+	score, err := ranker.Score([]string{"foo"}, "a")
+	if err != nil {
+		t.Error(err)
+	}
+	if score <= 0 {
+		t.Errorf("Expected a positive score for a matching term; got %v", score)
+	}
+
+	score, err = ranker.Score([]string{"absent"}, "a")
+	if err != nil {
+		t.Error(err)
+	}
+	if score != 0 {
+		t.Errorf("Expected 0 for a non-matching term; got %v", score)
+	}
+}
+
+// TestBM25RankerUsesDocumentFrequencyNotCollectionFrequency guards against
+// df(t) being conflated with dfCache's corpus-wide token count: if a term
+// occurs many times in one document, that repetition must not inflate df(t)
+// past N and drive IDF (and therefore the score of a genuinely matching
+// document) negative.
+func TestBM25RankerUsesDocumentFrequencyNotCollectionFrequency(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() {
+		err := os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	location := filepath.Join(tempDir, "v")
+	v, err := OpenVectorIndex(location)
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() {
+		err := v.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	for _, input := range []struct {
+		filename string
+		contents string
+	}{
+		{"a", "hello hello hello hello world"},
+		{"b", "hello tiger tiger"},
+		{"c", "rumic tiger"},
+	} {
+		err := v.Add(input.filename, input.contents)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	ranker := NewBM25Ranker(v)
+	for _, doc := range []string{"a", "b"} {
+		score, err := ranker.Score([]string{"hello"}, doc)
+		if err != nil {
+			t.Error(err)
+		}
+		if score <= 0 {
+			t.Errorf(
+				"Expected a positive score for doc %q matching \"hello\"; got %v",
+				doc,
+				score)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"sync"
 
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
 )
 
 // We consider documents (and queries) to be elements of an N-dimensional vector
@@ -33,25 +34,71 @@ import (
 // Note that this implies filenames cannot contain '\0'
 const ftPrefix = "ft"
 
-// term -> number of times a term appears in the corpus
+// term -> number of documents containing term at least once, i.e. the true
+// document frequency df(t) that the IDF formulas in ranker.go call for. Note
+// this is NOT the same as a collection frequency (which would count every
+// occurrence of term across all documents): a term repeated many times
+// within one document must only count once here, or df(t) can exceed N and
+// drive IDF negative.
 const dfPrefix = "df"
 
 // term\0filename -> number of time a term appears in a document
 // Note that this implies terms cannot contain '\0'
 const tfPrefix = "tf"
 
+// term\0filename -> the sorted token offsets at which term occurs in
+// filename, varint-delta-encoded (see encodePositions). Used to answer
+// phrase and NEAR/k proximity queries.
+const posPrefix = "pos"
+
+// dl<filename> -> number of tokens in the document, used for BM25's document
+// length normalization and to maintain avgdl
+const dlPrefix = "dl"
+
+// exact key holding the total number of documents currently in the index
+// (Delete decrements it); this is N in both the TF-IDF and BM25 scoring
+// formulas
+const docCountKey = "N"
+
+// exact key holding the sum of the lengths (in tokens) of every document
+// currently in the index; together with docCountKey this gives
+// avgdl for BM25 scoring
+const corpusLengthKey = "L"
+
 type VectorIndex struct {
 	sync.Mutex
-	db      *leveldb.DB
-	dfCache map[string]uint32
+	db        *leveldb.DB
+	dfCache   map[string]uint32
+	docCount  uint32
+	corpusLen uint32
+	writeSync bool
+	analyzer  Analyzer
 }
 
 var _ Index = (*VectorIndex)(nil)
 
-func loadDFCache(db *leveldb.DB) (map[string]uint32, error) {
-	dfCache := make(map[string]uint32)
+// VectorIndexOptions controls optional behavior of a VectorIndex.
+type VectorIndexOptions struct {
+	// WriteSync, when true, makes Add block until its batch has been synced
+	// to disk. This is slower, but guarantees that a crash immediately after
+	// Add returns cannot lose or partially apply the write. When false (the
+	// default), writes are still atomic but may be lost on a crash before
+	// the OS flushes them.
+	WriteSync bool
+
+	// Analyzer controls how Add and Search turn text into terms. Defaults
+	// to whitespace-only tokenization, matching the index's original
+	// behavior, if not given.
+	Analyzer Analyzer
+}
+
+// loadCounterMap reads every <prefix><term> -> uint32 entry into a
+// map keyed by the term (the part of the key after prefix). It's used to
+// rebuild dfCache from disk when opening an index.
+func loadCounterMap(db *leveldb.DB, prefix string) (map[string]uint32, error) {
+	counterMap := make(map[string]uint32)
 	iterFunc := func(key []byte, value []byte) error {
-		term := string(key[len(dfPrefix):])
+		term := string(key[len(prefix):])
 		if len(value) != 4 {
 			errorMessage := fmt.Sprintf(
 				"Key %v has invalid encoding of uint32 value: %v",
@@ -59,95 +106,356 @@ func loadDFCache(db *leveldb.DB) (map[string]uint32, error) {
 				value)
 			return errors.New(errorMessage)
 		}
-		dfCache[term] = binary.BigEndian.Uint32(value)
+		counterMap[term] = binary.BigEndian.Uint32(value)
 		return nil
 	}
-	err := iterate(db, []byte(dfPrefix), iterFunc)
+	err := iterate(db, []byte(prefix), iterFunc)
 	if err != nil {
 		return nil, err
 	}
-	return dfCache, nil
+	return counterMap, nil
+}
+
+// loadCounter reads the uint32 stored at an exact (non-prefix) key, treating
+// a missing key as 0.
+func loadCounter(db *leveldb.DB, key []byte) (uint32, error) {
+	has, err := db.Has(key, nil)
+	if err != nil {
+		return 0, err
+	}
+	if !has {
+		return 0, nil
+	}
+	valueBytes, err := db.Get(key, nil)
+	if err != nil {
+		return 0, err
+	}
+	if len(valueBytes) != 4 {
+		errorMessage := fmt.Sprintf(
+			"Key %v has invalid encoding of uint32 value: %v",
+			key,
+			valueBytes)
+		return 0, errors.New(errorMessage)
+	}
+	return binary.BigEndian.Uint32(valueBytes), nil
 }
 
 func OpenVectorIndex(location string) (*VectorIndex, error) {
+	return OpenVectorIndexWithOptions(location, VectorIndexOptions{})
+}
+
+func OpenVectorIndexWithOptions(
+	location string,
+	options VectorIndexOptions) (*VectorIndex, error) {
+
 	db, err := leveldb.OpenFile(location, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	dfCache, err := loadDFCache(db)
+	dfCache, err := loadCounterMap(db, dfPrefix)
+	if err != nil {
+		return nil, err
+	}
+	docCount, err := loadCounter(db, []byte(docCountKey))
+	if err != nil {
+		return nil, err
+	}
+	corpusLen, err := loadCounter(db, []byte(corpusLengthKey))
+	if err != nil {
+		return nil, err
+	}
+
+	analyzer := options.Analyzer
+	if analyzer == nil {
+		analyzer = defaultAnalyzer{}
+	}
+	if err := checkAnalyzer(db, analyzer); err != nil {
+		return nil, err
+	}
+
 	return &VectorIndex{
-		db:      db,
-		dfCache: dfCache,
+		db:        db,
+		dfCache:   dfCache,
+		docCount:  docCount,
+		corpusLen: corpusLen,
+		writeSync: options.WriteSync,
+		analyzer:  analyzer,
 	}, nil
 }
 
-func (v *VectorIndex) increment(keyBytes []byte) error {
+// batchAdjust reads the current uint32 counter stored at keyBytes, adds
+// delta (which may be negative) to it, and stages the new value as a Put on
+// batch. It returns the new value so callers can keep in-memory state (e.g.
+// dfCache) in sync once the batch is successfully written.
+func (v *VectorIndex) batchAdjust(
+	batch *leveldb.Batch,
+	keyBytes []byte,
+	delta int64) (uint32, error) {
+
 	has, err := v.db.Has(keyBytes, nil)
-	if !has {
-		valueBytes := make([]byte, 4)
-		binary.BigEndian.PutUint32(valueBytes, 1)
-		return v.db.Put(keyBytes, valueBytes, nil)
-	}
-	valueBytes, err := v.db.Get(keyBytes, nil)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	var value uint32
-	if len(valueBytes) == 0 {
-		value = 0
-	} else if len(valueBytes) == 4 {
+	if has {
+		valueBytes, err := v.db.Get(keyBytes, nil)
+		if err != nil {
+			return 0, err
+		}
+		if len(valueBytes) != 4 {
+			errorMessage := fmt.Sprintf(
+				"Key %v has invalid encoding of uint32 value: %v",
+				keyBytes,
+				valueBytes)
+			return 0, errors.New(errorMessage)
+		}
 		value = binary.BigEndian.Uint32(valueBytes)
-	} else {
+	}
+
+	newValue := int64(value) + delta
+	if newValue < 0 {
 		errorMessage := fmt.Sprintf(
-			"Key %v has invalid encoding of uint32 value: %v",
+			"Key %v has value %d and cannot be adjusted by %d",
 			keyBytes,
-			valueBytes)
-		return errors.New(errorMessage)
+			value,
+			delta)
+		return 0, errors.New(errorMessage)
 	}
-
-	if value == math.MaxUint32 {
+	if newValue > math.MaxUint32 {
 		errorMessage := fmt.Sprintf(
-			"Key %v has value %d and cannot be incremented anymore",
+			"Key %v has value %d and cannot be adjusted by %d",
 			keyBytes,
-			value)
-		return errors.New(errorMessage)
+			value,
+			delta)
+		return 0, errors.New(errorMessage)
 	}
-	binary.BigEndian.PutUint32(valueBytes, value+1)
-	return v.db.Put(keyBytes, valueBytes, nil)
+
+	valueBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(valueBytes, uint32(newValue))
+	batch.Put(keyBytes, valueBytes)
+	return uint32(newValue), nil
+}
+
+// batchIncrement is batchAdjust restricted to non-negative deltas.
+func (v *VectorIndex) batchIncrement(
+	batch *leveldb.Batch,
+	keyBytes []byte,
+	delta uint32) (uint32, error) {
+
+	return v.batchAdjust(batch, keyBytes, int64(delta))
+}
+
+// collectRemoval stages the deletion of filename's existing ft/tf/pos/dl
+// postings (if any) into batch, and returns the df, docCount, and corpusLen
+// deltas needed to back their contribution out of the corpus-wide counters.
+// It returns a zero docCountDelta and an empty dfDeltas if filename was
+// never added. It's the shared core of Delete and of making Add idempotent
+// when re-adding an already-indexed file: the ft/tf/pos/dl keys it touches
+// are owned entirely by filename, so Add can simply overwrite them with
+// freshly computed values once their prior contribution to df/N/corpusLen
+// has been subtracted out here.
+//
+// Whether filename was already indexed is determined by the presence of its
+// dl<filename> key (see dlPrefix) rather than by whether it has any ft
+// postings: a document that analyzes to zero terms (e.g. entirely
+// stopwords) still gets a dl entry from Add, but would never show up in the
+// ft range, and must still be recognized as already-indexed so docCount
+// isn't charged twice.
+func (v *VectorIndex) collectRemoval(
+	batch *leveldb.Batch,
+	filename string) (map[string]int64, int64, int64, error) {
+
+	dlKey := []byte(dlPrefix)
+	dlKey = append(dlKey, []byte(filename)...)
+	wasIndexed, err := v.db.Has(dlKey, nil)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if !wasIndexed {
+		return map[string]int64{}, 0, 0, nil
+	}
+
+	termFreqs, err := v.documentTermFreqs(filename)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	dfDeltas := make(map[string]int64, len(termFreqs))
+	var docLength uint32
+	for term, tf := range termFreqs {
+		ftKey := []byte(ftPrefix)
+		ftKey = append(ftKey, joinWithNullSep(filename, term)...)
+		batch.Delete(ftKey)
+
+		tfKey := []byte(tfPrefix)
+		tfKey = append(tfKey, joinWithNullSep(term, filename)...)
+		batch.Delete(tfKey)
+
+		posKey := []byte(posPrefix)
+		posKey = append(posKey, joinWithNullSep(term, filename)...)
+		batch.Delete(posKey)
+
+		// filename contributed exactly one occurrence to term's document
+		// frequency, regardless of how many times term appears in it.
+		dfDeltas[term] -= 1
+		docLength += tf
+	}
+
+	batch.Delete(dlKey)
+
+	return dfDeltas, -1, -int64(docLength), nil
 }
 
 func (v *VectorIndex) Add(filename string, contents string) error {
-	tokens := tokenize(contents)
-	for _, token := range tokens {
+	tokens := v.analyzer.Analyze(contents)
+	docLength := uint32(len(tokens))
+
+	batch := new(leveldb.Batch)
+
+	// If filename was already indexed, back its prior postings out of the
+	// df/docCount/corpusLen counters and stage their deletion, so that
+	// re-Adding a file is idempotent rather than accumulating stale counts.
+	dfDeltas, docCountDelta, corpusLenDelta, err := v.collectRemoval(batch, filename)
+	if err != nil {
+		return err
+	}
+
+	// ft, tf, and pos postings are owned entirely by (filename, term), so
+	// they're simply overwritten with this Add's counts rather than
+	// incremented.
+	ftCounts := make(map[string]uint32)
+	positions := make(map[string][]uint32)
+	for i, token := range tokens {
+		ftCounts[token]++
+		positions[token] = append(positions[token], uint32(i))
+	}
+
+	for token, count := range ftCounts {
+		countBytes := make([]byte, 4)
+		binary.BigEndian.PutUint32(countBytes, count)
+
 		ftKey := []byte(ftPrefix)
 		ftKey = append(ftKey, joinWithNullSep(filename, token)...)
+		batch.Put(ftKey, countBytes)
+
+		tfKey := []byte(tfPrefix)
+		tfKey = append(tfKey, joinWithNullSep(token, filename)...)
+		batch.Put(tfKey, countBytes)
 
+		// filename contributes exactly one occurrence to token's document
+		// frequency, regardless of count.
+		dfDeltas[token] += 1
+	}
+	for token, tokenPositions := range positions {
+		posKey := []byte(posPrefix)
+		posKey = append(posKey, joinWithNullSep(token, filename)...)
+		batch.Put(posKey, encodePositions(tokenPositions))
+	}
+
+	dlKey := []byte(dlPrefix)
+	dlKey = append(dlKey, []byte(filename)...)
+	dlValueBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(dlValueBytes, docLength)
+	batch.Put(dlKey, dlValueBytes)
+
+	newDF := make(map[string]uint32, len(dfDeltas))
+	for token, delta := range dfDeltas {
+		if delta == 0 {
+			continue
+		}
 		dfKey := []byte(dfPrefix)
 		dfKey = append(dfKey, []byte(token)...)
+		value, err := v.batchAdjust(batch, dfKey, delta)
+		if err != nil {
+			return err
+		}
+		newDF[token] = value
+	}
 
-		tfKey := []byte(tfPrefix)
-		tfKey = append(tfKey, joinWithNullSep(token, filename)...)
+	newDocCount, err := v.batchAdjust(batch, []byte(docCountKey), docCountDelta+1)
+	if err != nil {
+		return err
+	}
+	newCorpusLen, err := v.batchAdjust(
+		batch, []byte(corpusLengthKey), corpusLenDelta+int64(docLength))
+	if err != nil {
+		return err
+	}
 
-		for _, keyBytes := range [][]byte{ftKey, dfKey, tfKey} {
-			err := v.increment(keyBytes)
-			if err != nil {
-				return err
-			}
+	err = v.db.Write(batch, &opt.WriteOptions{Sync: v.writeSync})
+	if err != nil {
+		return err
+	}
+
+	// Only update in-memory state once the batch has been successfully
+	// written, so a failed Write leaves it consistent with what's on disk.
+	for token, value := range newDF {
+		v.dfCache[token] = value
+	}
+	v.docCount = newDocCount
+	v.corpusLen = newCorpusLen
+	return nil
+}
+
+// Delete removes filename from the index, decrementing df for each term it
+// contributed along with docCount and corpusLen, so avgdl and df-based
+// scoring reflect only the documents still indexed. It's a no-op if
+// filename was never added.
+func (v *VectorIndex) Delete(filename string) error {
+	batch := new(leveldb.Batch)
+	dfDeltas, docCountDelta, corpusLenDelta, err := v.collectRemoval(batch, filename)
+	if err != nil {
+		return err
+	}
+	if docCountDelta == 0 {
+		return nil
+	}
+
+	newDF := make(map[string]uint32, len(dfDeltas))
+	for token, delta := range dfDeltas {
+		dfKey := []byte(dfPrefix)
+		dfKey = append(dfKey, []byte(token)...)
+		value, err := v.batchAdjust(batch, dfKey, delta)
+		if err != nil {
+			return err
 		}
-		v.dfCache[token]++
+		newDF[token] = value
 	}
+
+	newDocCount, err := v.batchAdjust(batch, []byte(docCountKey), docCountDelta)
+	if err != nil {
+		return err
+	}
+	newCorpusLen, err := v.batchAdjust(batch, []byte(corpusLengthKey), corpusLenDelta)
+	if err != nil {
+		return err
+	}
+
+	err = v.db.Write(batch, &opt.WriteOptions{Sync: v.writeSync})
+	if err != nil {
+		return err
+	}
+
+	for token, value := range newDF {
+		v.dfCache[token] = value
+	}
+	v.docCount = newDocCount
+	v.corpusLen = newCorpusLen
 	return nil
 }
 
-func (v *VectorIndex) documentVector(
-	filename string) (map[string]float64, error) {
+// documentTermFreqs returns the raw term frequency f(t, filename) for every
+// term the document contains; it's the shared building block both Ranker
+// implementations use to compute their own term weights.
+func (v *VectorIndex) documentTermFreqs(
+	filename string) (map[string]uint32, error) {
 
 	prefix := []byte(ftPrefix)
 	prefix = append(prefix, []byte(filename)...)
 	prefix = append(prefix, nullByte)
-	result := make(map[string]float64)
+	result := make(map[string]uint32)
 	iterFunc := func(key []byte, value []byte) error {
 		term := string(key[len(prefix):])
 		if len(value) != 4 {
@@ -157,9 +465,7 @@ func (v *VectorIndex) documentVector(
 				value)
 			return errors.New(errorMessage)
 		}
-		tf := binary.BigEndian.Uint32(value)
-		df := v.dfCache[term]
-		result[term] = float64(tf) / float64(df)
+		result[term] = binary.BigEndian.Uint32(value)
 		return nil
 	}
 	err := iterate(v.db, prefix, iterFunc)
@@ -169,55 +475,26 @@ func (v *VectorIndex) documentVector(
 	return result, nil
 }
 
-func (v *VectorIndex) queryVector(
-	query string) map[string]float64 {
-
-	queryTF := make(map[string]uint32)
-	for _, token := range tokenize(query) {
-		queryTF[token]++
-	}
-	result := make(map[string]float64)
-	for term, tf := range queryTF {
-		df, ok := v.dfCache[term]
-		if ok {
-			result[term] = float64(tf) / float64(df)
-		}
-	}
-	return result
-}
-
-func dotProduct(x map[string]float64, y map[string]float64) float64 {
-	result := float64(0)
-	for term, tfIDF := range x {
-		result += tfIDF * y[term]
-	}
-	return result
+// documentLength returns the number of tokens in filename, as recorded by
+// the most recent Add of that file.
+func (v *VectorIndex) documentLength(filename string) (uint32, error) {
+	key := []byte(dlPrefix)
+	key = append(key, []byte(filename)...)
+	return loadCounter(v.db, key)
 }
 
-func magnitude(vector map[string]float64) float64 {
-	result := float64(0)
-	for _, tfIDF := range vector {
-		result += math.Pow(tfIDF, 2)
+// averageDocumentLength is avgdl in the BM25 scoring formula.
+func (v *VectorIndex) averageDocumentLength() float64 {
+	if v.docCount == 0 {
+		return 0
 	}
-	return result
+	return float64(v.corpusLen) / float64(v.docCount)
 }
 
-func (v *VectorIndex) queryDocumentSimilarity(
-	query string,
-	filename string) (float64, error) {
-
-	q := v.queryVector(query)
-	d, err := v.documentVector(filename)
-	if err != nil {
-		return 0, err
-	}
-	return dotProduct(q, d) / (magnitude(q) * magnitude(d)), nil
-}
-
-func uniqueTerms(query string) map[string]struct{} {
+func uniqueTerms(terms []string) map[string]struct{} {
 	result := make(map[string]struct{})
-	for _, token := range tokenize(query) {
-		result[token] = struct{}{}
+	for _, term := range terms {
+		result[term] = struct{}{}
 	}
 	return result
 }
@@ -233,10 +510,45 @@ func (s byScore) Len() int           { return len(s) }
 func (s byScore) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 func (s byScore) Less(i, j int) bool { return s[i].score > s[j].score }
 
+// Search ranks documents using the default Ranker (BM25). Use
+// SearchWithRanker to pick a different Ranker, e.g. TFIDFRanker.
 func (v *VectorIndex) Search(query string) ([]string, error) {
-	candidates := make(map[string]struct{})
+	return v.SearchWithRanker(query, nil)
+}
+
+// SearchWithRanker ranks documents using ranker. A nil ranker defaults to
+// BM25. query may contain double-quoted phrases and NEAR/k clauses (see
+// ParseQuery); a *BM25Ranker additionally gets a proximity boost for
+// documents that satisfy them (see BM25Ranker.WithPhraseBoosts).
+func (v *VectorIndex) SearchWithRanker(
+	query string,
+	ranker Ranker) ([]string, error) {
 
-	for term, _ := range uniqueTerms(query) {
+	if ranker == nil {
+		ranker = NewBM25Ranker(v)
+	}
+
+	parsedQuery := ParseQuery(query, v.analyzer)
+	queryTerms := parsedQuery.AllTerms()
+
+	if bm25, ok := ranker.(*BM25Ranker); ok && len(parsedQuery.Phrases) > 0 {
+		phraseGaps := make(map[string]int)
+		for _, phrase := range parsedQuery.Phrases {
+			gaps, err := v.phraseSearch(phrase.Terms, phrase.Slop)
+			if err != nil {
+				return nil, err
+			}
+			for filename, gap := range gaps {
+				if best, ok := phraseGaps[filename]; !ok || gap < best {
+					phraseGaps[filename] = gap
+				}
+			}
+		}
+		ranker = bm25.WithPhraseBoosts(phraseGaps)
+	}
+
+	candidates := make(map[string]struct{})
+	for term, _ := range uniqueTerms(queryTerms) {
 		prefix := []byte(tfPrefix)
 		prefix = append(prefix, []byte(term)...)
 		prefix = append(prefix, nullByte)
@@ -260,7 +572,7 @@ func (v *VectorIndex) Search(query string) ([]string, error) {
 
 	scoredCandidates := make([]*scoredCandidate, 0, len(candidates))
 	for candidate, _ := range candidates {
-		score, err := v.queryDocumentSimilarity(query, candidate)
+		score, err := ranker.Score(queryTerms, candidate)
 		if err != nil {
 			return nil, err
 		}
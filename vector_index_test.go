@@ -6,6 +6,8 @@ import (
 	"path/filepath"
 	"reflect"
 	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
 )
 
 func TestVectorIndexDFCache(t *testing.T) {
@@ -32,7 +34,7 @@ func TestVectorIndexDFCache(t *testing.T) {
 		t.Error(err)
 	}
 	expected := map[string]uint32{
-		"foo": 2,
+		"foo": 1,
 		"bar": 1,
 		"baz": 1,
 	}
@@ -42,13 +44,17 @@ func TestVectorIndexDFCache(t *testing.T) {
 			expected,
 			v.dfCache)
 	}
+	// "foo" and "bar" each appear in one more document (twice each), so
+	// (unlike a collection frequency that counts every occurrence) their
+	// df entries only go up by 1 regardless of how many times they repeat
+	// in file2.txt.
 	err = v.Add("file2.txt", "bar bar foo foo bat")
 	if err != nil {
 		t.Error(err)
 	}
 	expected = map[string]uint32{
-		"foo": 4,
-		"bar": 3,
+		"foo": 2,
+		"bar": 2,
 		"baz": 1,
 		"bat": 1,
 	}
@@ -70,7 +76,7 @@ func TestVectorIndexDFCache(t *testing.T) {
 		t.Errorf(
 			"Expected %v; got %v",
 			expected,
-			v.dfCache)
+			v2.dfCache)
 	}
 	err = v2.Close()
 	if err != nil {
@@ -78,7 +84,7 @@ func TestVectorIndexDFCache(t *testing.T) {
 	}
 }
 
-func TestVectorIndexIncrement(t *testing.T) {
+func TestVectorIndexBatchIncrement(t *testing.T) {
 	// Initialization
 	tempDir, err := ioutil.TempDir("", "")
 	if err != nil {
@@ -104,7 +110,15 @@ func TestVectorIndexIncrement(t *testing.T) {
 	}()
 
 	key := []byte{1, 2, 3, 4}
-	err = v.increment(key)
+	batch := new(leveldb.Batch)
+	value, err := v.batchIncrement(batch, key, 1)
+	if err != nil {
+		t.Error(err)
+	}
+	if value != 1 {
+		t.Errorf("Expected 1; got %d", value)
+	}
+	err = v.db.Write(batch, nil)
 	if err != nil {
 		t.Error(err)
 	}
@@ -120,7 +134,16 @@ func TestVectorIndexIncrement(t *testing.T) {
 			key,
 			actual)
 	}
-	err = v.increment(key)
+
+	batch = new(leveldb.Batch)
+	value, err = v.batchIncrement(batch, key, 1)
+	if err != nil {
+		t.Error(err)
+	}
+	if value != 2 {
+		t.Errorf("Expected 2; got %d", value)
+	}
+	err = v.db.Write(batch, nil)
 	if err != nil {
 		t.Error(err)
 	}
@@ -138,7 +161,7 @@ func TestVectorIndexIncrement(t *testing.T) {
 	}
 }
 
-func TestVectorIndexVectors(t *testing.T) {
+func TestVectorIndexDocumentTermFreqs(t *testing.T) {
 	// Initialization
 	tempDir, err := ioutil.TempDir("", "")
 	if err != nil {
@@ -167,11 +190,11 @@ func TestVectorIndexVectors(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	actual, err := v.documentVector("file.txt")
+	actual, err := v.documentTermFreqs("file.txt")
 	if err != nil {
 		t.Error(err)
 	}
-	expected := map[string]float64{
+	expected := map[string]uint32{
 		"foo": 1,
 		"bar": 1,
 		"baz": 1,
@@ -186,12 +209,12 @@ func TestVectorIndexVectors(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	actual, err = v.documentVector("file.txt")
-	expected = map[string]float64{
-		"foo": 0.5,
-		"bar": 0.5,
-		"baz": 0.5,
+	actual, err = v.documentTermFreqs("file.txt")
+	if err != nil {
+		t.Error(err)
 	}
+	// file.txt's own term frequencies are unaffected by other documents
+	// being added.
 	if !reflect.DeepEqual(expected, actual) {
 		t.Errorf(
 			"Expected %v; got %v",